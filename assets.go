@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/disintegration/imaging"
+	"github.com/tdewolff/minify/v2"
+	"github.com/tdewolff/minify/v2/css"
+	"github.com/tdewolff/minify/v2/js"
+	"golang.org/x/net/html"
+)
+
+// assetVariant — один сгенерированный вариант исходного ресурса
+// (уменьшенная копия картинки, минифицированный css/js).
+type assetVariant struct {
+	Width   int    // > 0 для image-вариантов, 0 для минифицированных
+	AbsPath string // путь на диске
+}
+
+// AssetProcessor — точка расширения конвейера обработки не-HTML
+// ресурсов. processAsset выбирает первый подходящий по content-type
+// процессор и отдает ему исходные байты.
+type AssetProcessor interface {
+	// Supports сообщает, обрабатывает ли процессор данный content-type.
+	Supports(contentType string) bool
+	// Process читает исходные данные и кладет производные варианты в
+	// genDir, который уникален для данного исходника (хеш содержимого),
+	// так что повторный прогон просто переиспользует уже лежащий файл.
+	Process(data []byte, genDir string) ([]assetVariant, error)
+}
+
+// parseImgSizes разбирает "-img-sizes=320,640,1280" в список ширин.
+func parseImgSizes(s string) []int {
+	if s == "" {
+		return nil
+	}
+	var sizes []int
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		n, err := strconv.Atoi(part)
+		if err != nil || n <= 0 {
+			continue
+		}
+		sizes = append(sizes, n)
+	}
+	return sizes
+}
+
+// parseMinifyTargets разбирает "-minify=css,js,html" в множество целей.
+func parseMinifyTargets(s string) map[string]bool {
+	targets := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(strings.ToLower(part))
+		if part != "" {
+			targets[part] = true
+		}
+	}
+	return targets
+}
+
+// imageProcessor генерирует под каждую запрошенную ширину отдельный
+// resize-вариант картинки (см. -img-sizes).
+type imageProcessor struct {
+	sizes []int
+}
+
+func (p *imageProcessor) Supports(contentType string) bool {
+	return strings.HasPrefix(contentType, "image/")
+}
+
+func (p *imageProcessor) Process(data []byte, genDir string) ([]assetVariant, error) {
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(genDir, 0755); err != nil {
+		return nil, err
+	}
+
+	var variants []assetVariant
+	for _, w := range p.sizes {
+		outPath := filepath.Join(genDir, fmt.Sprintf("w%d.%s", w, format))
+
+		// кэш по хешу исходника: вариант такой ширины уже лежит на диске
+		if _, err := os.Stat(outPath); err == nil {
+			variants = append(variants, assetVariant{Width: w, AbsPath: outPath})
+			continue
+		}
+
+		resized := imaging.Resize(img, w, 0, imaging.Lanczos)
+		if err := imaging.Save(resized, outPath); err != nil {
+			return variants, err
+		}
+		variants = append(variants, assetVariant{Width: w, AbsPath: outPath})
+	}
+	return variants, nil
+}
+
+// minifyProcessor прогоняет css/js через tdewolff/minify и кладет
+// единственный "min.<ext>" вариант.
+type minifyProcessor struct {
+	targets map[string]bool
+}
+
+func (p *minifyProcessor) Supports(contentType string) bool {
+	switch {
+	case strings.Contains(contentType, "text/css"):
+		return p.targets["css"]
+	case strings.Contains(contentType, "javascript"):
+		return p.targets["js"]
+	default:
+		return false
+	}
+}
+
+func (p *minifyProcessor) Process(data []byte, genDir string) ([]assetVariant, error) {
+	var ext, mime string
+	if p.targets["css"] && looksLikeCSS(data) {
+		ext, mime = "css", "text/css"
+	} else {
+		ext, mime = "js", "application/javascript"
+	}
+
+	outPath := filepath.Join(genDir, "min."+ext)
+	if _, err := os.Stat(outPath); err == nil {
+		return []assetVariant{{AbsPath: outPath}}, nil
+	}
+
+	if err := os.MkdirAll(genDir, 0755); err != nil {
+		return nil, err
+	}
+
+	m := minify.New()
+	m.AddFunc("text/css", css.Minify)
+	m.AddFunc("application/javascript", js.Minify)
+
+	minified, err := m.Bytes(mime, data)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(outPath, minified, 0644); err != nil {
+		return nil, err
+	}
+	return []assetVariant{{AbsPath: outPath}}, nil
+}
+
+func looksLikeCSS(data []byte) bool {
+	return bytes.Contains(data, []byte("{")) && !bytes.Contains(data, []byte("function"))
+}
+
+// variantsFor возвращает ранее сгенерированные варианты ресурса, если
+// конвейер его обрабатывал.
+func (d *downloader) variantsFor(urlStr string) ([]assetVariant, bool) {
+	d.assetMu.Lock()
+	defer d.assetMu.Unlock()
+	v, ok := d.assetVariants[urlStr]
+	return v, ok
+}
+
+// setAttr добавляет атрибут к узлу или обновляет его, если он уже есть.
+func setAttr(n *html.Node, key, val string) {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			n.Attr[i].Val = val
+			return
+		}
+	}
+	n.Attr = append(n.Attr, html.Attribute{Key: key, Val: val})
+}
+
+// processAsset отдает уже сохраненный файл первому подходящему по
+// content-type процессору и запоминает сгенерированные варианты, чтобы
+// rewriteLinks потом мог сослаться на них вместо оригинала.
+func (d *downloader) processAsset(urlStr, localPath, contentType string) {
+	if len(d.processors) == 0 {
+		return
+	}
+
+	var proc AssetProcessor
+	for _, p := range d.processors {
+		if p.Supports(contentType) {
+			proc = p
+			break
+		}
+	}
+	if proc == nil {
+		return
+	}
+
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return
+	}
+
+	sum, err := hashFile(localPath)
+	if err != nil {
+		return
+	}
+	genDir := filepath.Join(d.rootDir, "_gen", sum[:16])
+
+	variants, err := proc.Process(data, genDir)
+	if err != nil {
+		fmt.Printf("Asset pipeline failed for %s: %v\n", urlStr, err)
+		return
+	}
+	if len(variants) == 0 {
+		return
+	}
+
+	d.assetMu.Lock()
+	d.assetVariants[urlStr] = variants
+	d.assetMu.Unlock()
+}
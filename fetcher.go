@@ -0,0 +1,221 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/chromedp/cdproto/emulation"
+	"github.com/chromedp/cdproto/network"
+	"github.com/chromedp/chromedp"
+	"golang.org/x/net/html"
+)
+
+// fetchResult — то, что Fetcher возвращает вызывающему коду, независимо
+// от того, статический это GET или рендер в headless-браузере.
+type fetchResult struct {
+	statusCode   int
+	header       http.Header
+	body         []byte
+	subresources []*url.URL // урлы, перехваченные во время рендера (только headless)
+}
+
+// Fetcher — точка расширения между "как получить страницу" и остальным
+// конвейером (сохранение, collectLinks, rewriteLinks). httpFetcher - это
+// сегодняшний статический GET, headlessFetcher рендерит JS перед тем как
+// отдать DOM.
+type Fetcher interface {
+	Fetch(ctx context.Context, u *url.URL) (*fetchResult, error)
+}
+
+// httpFetcher — обертка над обычным d.client.Get для единообразия с
+// headlessFetcher. Основной путь (download()) пока ходит в client
+// напрямую, т.к. ему нужны conditional GET/Range, которых нет смысла
+// поддерживать в headless-режиме; httpFetcher остается готовой точкой
+// для полного перехода на Fetcher в будущем.
+type httpFetcher struct {
+	client    *http.Client
+	userAgent string
+}
+
+func (f *httpFetcher) Fetch(ctx context.Context, u *url.URL) (*fetchResult, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", f.userAgent)
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	return &fetchResult{statusCode: resp.StatusCode, header: resp.Header, body: body}, nil
+}
+
+// headlessFetcher драйвит Chromium через chromedp: дожидается готовности
+// <body> и затем фиксированные 500мс (сетевую активность он не
+// отслеживает), отдает пост-JS DOM, и попутно перехватывает каждый
+// подресурс, который браузер запросил - их не увидел бы обычный
+// html.Parse, если страница рисует себя через JS (<div id="app"></div>).
+// allocCtx - это один на весь прогон chromedp-аллокатор, browserCtx -
+// запущенный в нем браузер: каждый Fetch открывает в нем новую вкладку
+// вместо того чтобы запускать отдельный браузер на каждый вызов.
+type headlessFetcher struct {
+	userAgent   string
+	timeout     time.Duration
+	allocCtx    context.Context
+	browserCtx  context.Context
+	allocCancel context.CancelFunc
+}
+
+// newHeadlessFetcher запускает один браузерный процесс на весь прогон;
+// Close должен быть вызван при завершении программы, чтобы его прибить.
+func newHeadlessFetcher(userAgent string, timeout time.Duration) *headlessFetcher {
+	allocCtx, allocCancel := chromedp.NewExecAllocator(context.Background(), chromedp.DefaultExecAllocatorOptions[:]...)
+	// пустой Run поднимает браузер; контексты, созданные от browserCtx,
+	// наследуют его и получают в нем вкладку, а не свой процесс
+	browserCtx, browserCancel := chromedp.NewContext(allocCtx)
+	if err := chromedp.Run(browserCtx); err != nil {
+		fmt.Printf("Failed to start headless browser: %v\n", err)
+	}
+	cancel := func() {
+		browserCancel()
+		allocCancel()
+	}
+	return &headlessFetcher{userAgent: userAgent, timeout: timeout, allocCtx: allocCtx, browserCtx: browserCtx, allocCancel: cancel}
+}
+
+// Close останавливает общий браузерный процесс.
+func (f *headlessFetcher) Close() {
+	f.allocCancel()
+}
+
+func (f *headlessFetcher) Fetch(ctx context.Context, u *url.URL) (*fetchResult, error) {
+	// новая вкладка в уже запущенном браузере, а не новый процесс на
+	// каждый Fetch - тот же browserCtx шарится между всеми вызовами
+	ctx, cancel := chromedp.NewContext(f.browserCtx)
+	defer cancel()
+
+	if f.timeout > 0 {
+		var timeoutCancel context.CancelFunc
+		ctx, timeoutCancel = context.WithTimeout(ctx, f.timeout)
+		defer timeoutCancel()
+	}
+
+	var mu sync.Mutex
+	var subresources []*url.URL
+	statusCode := http.StatusOK // перезапишется, как только увидим ответ на сам документ
+	target := u.String()
+
+	chromedp.ListenTarget(ctx, func(ev interface{}) {
+		switch e := ev.(type) {
+		case *network.EventRequestWillBeSent:
+			parsed, err := url.Parse(e.Request.URL)
+			if err != nil {
+				return
+			}
+			mu.Lock()
+			subresources = append(subresources, parsed)
+			mu.Unlock()
+		case *network.EventResponseReceived:
+			if e.Response.URL == target {
+				mu.Lock()
+				statusCode = int(e.Response.Status)
+				mu.Unlock()
+			}
+		}
+	})
+
+	var rendered string
+	err := chromedp.Run(ctx,
+		network.Enable(),
+		emulation.SetUserAgentOverride(f.userAgent),
+		chromedp.Navigate(target),
+		chromedp.WaitReady("body", chromedp.ByQuery),
+		// простое ожидание "затишья в сети" вместо полноценного
+		// network-idle трекера - достаточно для большинства SPA
+		chromedp.Sleep(500*time.Millisecond),
+		chromedp.OuterHTML("html", &rendered, chromedp.ByQuery),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("headless render failed for %s: %w", u, err)
+	}
+
+	return &fetchResult{
+		statusCode:   statusCode,
+		header:       http.Header{"Content-Type": []string{"text/html; charset=utf-8"}},
+		body:         []byte(rendered),
+		subresources: subresources,
+	}, nil
+}
+
+// downloadHeadless — ветка download() для -render=headless. Вызывается
+// уже после robots/filters/семафора/politeWait, поэтому тут остается
+// только получить DOM и прогнать его через тот же collectLinks/rewriteLinks,
+// что и статический путь.
+func (d *downloader) downloadHeadless(u *url.URL, urlStr, localPath string, depth int) {
+	result, err := d.fetcher.Fetch(context.Background(), u)
+	if err != nil {
+		fmt.Printf("ERROR %s: %v\n\n", urlStr, err)
+		return
+	}
+
+	if result.statusCode != http.StatusOK {
+		fmt.Printf("ERROR %s: server returned status %d\n\n", urlStr, result.statusCode)
+		return
+	}
+
+	if !d.filters.acceptSize(int64(len(result.body))) {
+		fmt.Printf("Skipping %s: size outside [--min-size, --max-size]\n\n", urlStr)
+		return
+	}
+
+	doc, err := html.Parse(bytes.NewReader(result.body))
+	if err != nil {
+		fmt.Printf("Failed to parse rendered HTML %s: %v\n\n", urlStr, err)
+		return
+	}
+
+	resources, pages := d.collectLinks(doc, u)
+
+	// подресурсы, которые увидел браузер, но не увидел бы статический
+	// парсер (подгруженные через JS) - довставляем в граф ресурсов
+	for _, sub := range result.subresources {
+		if d.allow(sub.Host, sub.Path) {
+			resources = append(resources, sub)
+		}
+	}
+
+	for _, res := range resources {
+		d.download(res, depth, false)
+	}
+
+	d.rewriteLinks(doc, u, localPath)
+
+	if err := d.saveHTML(localPath, doc); err != nil {
+		fmt.Printf("Failed to save HTML %s: %v\n\n", localPath, err)
+	} else {
+		d.recordManifestEntry(urlStr, localPath, result.header.Get("ETag"), result.header.Get("Last-Modified"))
+		if fi, err := os.Stat(localPath); err == nil {
+			d.filters.addDownloaded(fi.Size())
+		}
+	}
+	fmt.Printf("'%s' saved\n\n", urlStr)
+
+	for _, page := range pages {
+		d.download(page, depth+1, true)
+	}
+}
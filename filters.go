@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// HostPolicy решает, какие хосты участвуют в обходе: всегда исходный
+// хост зеркала, и, если включен -H, любой другой хост (опционально
+// ограниченный -D allowlist'ом доменов).
+type HostPolicy struct {
+	Primary string
+	Span    bool
+	Allow   map[string]bool // nil/пусто при Span == true значит "любой хост"
+}
+
+// Matches сообщает, участвует ли host в обходе согласно политике.
+func (p HostPolicy) Matches(host string) bool {
+	if host == p.Primary {
+		return true
+	}
+	if !p.Span {
+		return false
+	}
+	if len(p.Allow) == 0 {
+		return true
+	}
+	return p.Allow[host]
+}
+
+// filterSet собирает -A/-R/-I/-X/--max-size/--min-size/-Q в одно место,
+// т.к. все это - предикаты "стоит ли вообще качать этот урл", применяемые
+// до похода в сеть.
+type filterSet struct {
+	accept      []string // -A: glob по имени файла
+	reject      []string // -R: glob по имени файла
+	includeDirs []string // -I: префиксы пути
+	excludeDirs []string // -X: префиксы пути
+	maxSize     int64    // --max-size, 0 = без ограничения
+	minSize     int64    // --min-size, 0 = без ограничения
+	quota       int64    // -Q, 0 = без ограничения
+	downloaded  int64    // накопленный объем в байтах (atomic)
+}
+
+func splitCSV(s string) []string {
+	if s == "" {
+		return nil
+	}
+	var out []string
+	for _, p := range strings.Split(s, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// parseSize разбирает "10m"/"512k"/"2g"/"1024" в количество байт.
+func parseSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, nil
+	}
+
+	mult := int64(1)
+	switch last := strings.ToLower(s[len(s)-1:]); last {
+	case "k":
+		mult, s = 1024, s[:len(s)-1]
+	case "m":
+		mult, s = 1024*1024, s[:len(s)-1]
+	case "g":
+		mult, s = 1024*1024*1024, s[:len(s)-1]
+	}
+
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, err
+	}
+	return n * mult, nil
+}
+
+// acceptPath применяет -A/-R: reject побеждает, accept без совпадений
+// при непустом списке считается отказом.
+func (f *filterSet) acceptPath(urlPath string) bool {
+	base := path.Base(urlPath)
+
+	for _, pat := range f.reject {
+		if ok, _ := filepath.Match(pat, base); ok {
+			return false
+		}
+	}
+	if len(f.accept) == 0 {
+		return true
+	}
+	for _, pat := range f.accept {
+		if ok, _ := filepath.Match(pat, base); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// acceptDir применяет -I/-X: urlPath должен лежать под одной из -I
+// директорий (если он задан) и не лежать под -X.
+func (f *filterSet) acceptDir(urlPath string) bool {
+	dir := path.Dir(urlPath)
+
+	for _, p := range f.excludeDirs {
+		if dirUnder(dir, p) {
+			return false
+		}
+	}
+	if len(f.includeDirs) == 0 {
+		return true
+	}
+	for _, p := range f.includeDirs {
+		if dirUnder(dir, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirUnder сообщает, лежит ли dir внутри p, считая границы сегментов пути -
+// простого strings.HasPrefix(dir, p) недостаточно, иначе "/blog" ловит и
+// "/blogroll".
+func dirUnder(dir, p string) bool {
+	p = strings.TrimSuffix(p, "/")
+	return dir == p || strings.HasPrefix(dir, p+"/")
+}
+
+// acceptSize проверяет Content-Length против --max-size/--min-size.
+// contentLength < 0 значит "сервер не сказал": тогда здесь ничего не
+// отсекается, --max-size проверяется по мере чтения тела (io.LimitReader
+// в saveNonHTML и в html-ветке download), а --min-size для таких ответов
+// не проверяется.
+func (f *filterSet) acceptSize(contentLength int64) bool {
+	if contentLength < 0 {
+		return true
+	}
+	if f.maxSize > 0 && contentLength > f.maxSize {
+		return false
+	}
+	if f.minSize > 0 && contentLength < f.minSize {
+		return false
+	}
+	return true
+}
+
+// quotaExceeded сообщает, исчерпана ли -Q квота на весь прогон.
+func (f *filterSet) quotaExceeded() bool {
+	return f.quota > 0 && atomic.LoadInt64(&f.downloaded) >= f.quota
+}
+
+// addDownloaded учитывает фактически записанные байты для -Q.
+func (f *filterSet) addDownloaded(n int64) {
+	atomic.AddInt64(&f.downloaded, n)
+}
+
+// allow — единая точка входа, вызывается до захвата семафора, чтобы
+// запрещенные урлы не тратили сетевой запрос.
+func (d *downloader) allow(urlHost, urlPath string) bool {
+	if !d.hosts.Matches(urlHost) {
+		return false
+	}
+	if d.filters.quotaExceeded() {
+		return false
+	}
+	if !d.filters.acceptPath(urlPath) {
+		return false
+	}
+	if !d.filters.acceptDir(urlPath) {
+		return false
+	}
+	return true
+}
+
+func parseDomainAllowlist(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, d := range splitCSV(s) {
+		set[d] = true
+	}
+	return set
+}
+
+func mustParseSize(label, s string) int64 {
+	n, err := parseSize(s)
+	if err != nil {
+		fmt.Printf("Invalid %s value %q, ignoring: %v\n", label, s, err)
+		return 0
+	}
+	return n
+}
@@ -0,0 +1,412 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// cssURLRe находит url(...) внутри css (инлайновых style="", <style> и
+// внешних .css файлов). Кавычки вокруг значения необязательны.
+var cssURLRe = regexp.MustCompile(`url\(\s*['"]?([^'")]+)['"]?\s*\)`)
+
+// cssImportRe находит "голый" @import "x.css"; без обертки url(...).
+var cssImportRe = regexp.MustCompile(`@import\s+["']([^"']+)["']`)
+
+// resolve разбирает сырую ссылку относительно base и отсеивает все, что
+// не ведет на зеркалируемый хост.
+func (d *downloader) resolve(base *url.URL, raw string) (*url.URL, bool) {
+	absURL, err := base.Parse(raw)
+	if err != nil {
+		return nil, false
+	}
+	if absURL.Scheme == "" {
+		absURL.Scheme = base.Scheme
+	}
+	if !d.hosts.Matches(absURL.Host) || (absURL.Scheme != "http" && absURL.Scheme != "https") {
+		return nil, false
+	}
+	absURL.Fragment = ""
+	return absURL, true
+}
+
+// collectLinks обходит DOM и строит граф ресурсов страницы: ссылки на
+// другие страницы (a) отдельно от всего, что странице нужно для
+// отображения (css/js/img/video/audio/iframe/object, их srcset-кандидаты
+// и url()-ссылки внутри style="" и <style>).
+func (d *downloader) collectLinks(doc *html.Node, base *url.URL) (resources, pages []*url.URL) {
+	add := func(u *url.URL, isPage bool) {
+		if isPage {
+			pages = append(pages, u)
+		} else {
+			resources = append(resources, u)
+		}
+	}
+
+	// <meta name="robots" content="nofollow"> запрещает переход по любым
+	// ссылкам этой страницы - ресурсы, нужные для отображения, все равно
+	// собираем, речь только про pages.
+	pageNofollow := hasMetaNofollow(doc)
+
+	traverse(doc, func(n *html.Node) {
+		if n.Type == html.TextNode && n.Parent != nil && n.Parent.Data == "style" {
+			for _, u := range d.collectCSSResources(n.Data, base) {
+				add(u, false)
+			}
+			return
+		}
+
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		// style="..." может ссылаться на картинки/шрифты через url()
+		if style := attrVal(n, "style"); style != "" {
+			for _, u := range d.collectCSSResources(style, base) {
+				add(u, false)
+			}
+		}
+
+		switch n.Data {
+		case "a":
+			if pageNofollow || hasNofollowRel(n) {
+				return
+			}
+			if href := attrVal(n, "href"); href != "" {
+				if u, ok := d.resolve(base, href); ok {
+					add(u, true)
+				}
+			}
+		case "link":
+			if href := attrVal(n, "href"); href != "" {
+				if u, ok := d.resolve(base, href); ok {
+					add(u, false)
+				}
+			}
+		case "script":
+			if src := attrVal(n, "src"); src != "" {
+				if u, ok := d.resolve(base, src); ok {
+					add(u, false)
+				}
+			}
+		case "img", "source":
+			if src := attrVal(n, "src"); src != "" {
+				if u, ok := d.resolve(base, src); ok {
+					add(u, false)
+				}
+			}
+			if srcset := attrVal(n, "srcset"); srcset != "" {
+				for _, cand := range parseSrcset(srcset) {
+					if u, ok := d.resolve(base, cand.url); ok {
+						add(u, false)
+					}
+				}
+			}
+		case "video":
+			if poster := attrVal(n, "poster"); poster != "" {
+				if u, ok := d.resolve(base, poster); ok {
+					add(u, false)
+				}
+			}
+			if src := attrVal(n, "src"); src != "" {
+				if u, ok := d.resolve(base, src); ok {
+					add(u, false)
+				}
+			}
+		case "audio", "iframe":
+			if src := attrVal(n, "src"); src != "" {
+				if u, ok := d.resolve(base, src); ok {
+					add(u, false)
+				}
+			}
+		case "object":
+			if data := attrVal(n, "data"); data != "" {
+				if u, ok := d.resolve(base, data); ok {
+					add(u, false)
+				}
+			}
+		}
+	})
+
+	return resources, pages
+}
+
+// rewriteLinks перезаписывает тот же набор ссылок на относительные пути
+// до локальных копий.
+func (d *downloader) rewriteLinks(doc *html.Node, base *url.URL, currentLocalPath string) {
+	localize := func(raw string) (string, bool) {
+		absURL, ok := d.resolve(base, raw)
+		if !ok {
+			return "", false
+		}
+		targetLocalPath := d.makeLocalPath(absURL)
+		relPath, err := filepath.Rel(filepath.Dir(currentLocalPath), targetLocalPath)
+		if err != nil {
+			return "", false
+		}
+		if variants, ok := d.variantsFor(absURL.String()); ok {
+			d.applyVariantPath(variants, currentLocalPath, &relPath)
+		}
+		return relPath, true
+	}
+
+	traverse(doc, func(n *html.Node) {
+		if n.Type == html.TextNode && n.Parent != nil && n.Parent.Data == "style" {
+			n.Data = d.rewriteCSSText(n.Data, base, currentLocalPath)
+			return
+		}
+
+		if n.Type != html.ElementNode {
+			return
+		}
+
+		if style := attrVal(n, "style"); style != "" {
+			setAttr(n, "style", d.rewriteCSSText(style, base, currentLocalPath))
+		}
+
+		switch n.Data {
+		case "a", "link":
+			if href := attrVal(n, "href"); href != "" {
+				if rel, ok := localize(href); ok {
+					setAttr(n, "href", rel)
+				}
+			}
+		case "script":
+			if src := attrVal(n, "src"); src != "" {
+				if rel, ok := localize(src); ok {
+					setAttr(n, "src", rel)
+				}
+			}
+		case "img", "source":
+			var srcAbs *url.URL
+			if src := attrVal(n, "src"); src != "" {
+				srcAbs, _ = d.resolve(base, src)
+				if rel, ok := localize(src); ok {
+					setAttr(n, "src", rel)
+				}
+			}
+
+			var candidates []srcsetCandidate
+			if srcset := attrVal(n, "srcset"); srcset != "" {
+				candidates = parseSrcset(srcset)
+				for i := range candidates {
+					if rel, ok := localize(candidates[i].url); ok {
+						candidates[i].url = rel
+					}
+				}
+			}
+			// варианты, сгенерированные asset-конвейером (-img-sizes),
+			// добавляются к авторскому srcset, а не заменяют его
+			if srcAbs != nil {
+				if variants, ok := d.variantsFor(srcAbs.String()); ok {
+					for _, v := range variants {
+						if v.Width == 0 {
+							continue
+						}
+						if rel, err := filepath.Rel(filepath.Dir(currentLocalPath), v.AbsPath); err == nil {
+							candidates = append(candidates, srcsetCandidate{url: rel, descriptor: fmt.Sprintf("%dw", v.Width)})
+						}
+					}
+				}
+			}
+			if len(candidates) > 0 {
+				setAttr(n, "srcset", buildSrcset(candidates))
+			}
+		case "video":
+			if poster := attrVal(n, "poster"); poster != "" {
+				if rel, ok := localize(poster); ok {
+					setAttr(n, "poster", rel)
+				}
+			}
+			if src := attrVal(n, "src"); src != "" {
+				if rel, ok := localize(src); ok {
+					setAttr(n, "src", rel)
+				}
+			}
+		case "audio", "iframe":
+			if src := attrVal(n, "src"); src != "" {
+				if rel, ok := localize(src); ok {
+					setAttr(n, "src", rel)
+				}
+			}
+		case "object":
+			if data := attrVal(n, "data"); data != "" {
+				if rel, ok := localize(data); ok {
+					setAttr(n, "data", rel)
+				}
+			}
+		}
+	})
+}
+
+// applyVariantPath меняет relPath на путь к минифицированному варианту
+// css/js (если конвейер его сгенерировал); для <img> варианты добавляются
+// отдельно в srcset - см. блок "img", "source" выше.
+func (d *downloader) applyVariantPath(variants []assetVariant, currentLocalPath string, relPath *string) {
+	for _, v := range variants {
+		if v.Width != 0 {
+			continue
+		}
+		if rel, err := filepath.Rel(filepath.Dir(currentLocalPath), v.AbsPath); err == nil {
+			*relPath = rel
+		}
+	}
+}
+
+// hasMetaNofollow ищет <meta name="robots" content="..."> с nofollow
+// где-нибудь в документе (обычно в <head>, но проверяем все дерево).
+func hasMetaNofollow(doc *html.Node) bool {
+	found := false
+	traverse(doc, func(n *html.Node) {
+		if found || n.Type != html.ElementNode || n.Data != "meta" {
+			return
+		}
+		if !strings.EqualFold(attrVal(n, "name"), "robots") {
+			return
+		}
+		if hasToken(attrVal(n, "content"), "nofollow") {
+			found = true
+		}
+	})
+	return found
+}
+
+// hasNofollowRel проверяет rel="nofollow" на конкретном <a>.
+func hasNofollowRel(n *html.Node) bool {
+	return hasToken(attrVal(n, "rel"), "nofollow")
+}
+
+// hasToken ищет comma/space-разделенный токен без учета регистра, как
+// того требует формат rel="" и meta content="".
+func hasToken(list, token string) bool {
+	for _, f := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == ' ' }) {
+		if strings.EqualFold(f, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// attrVal возвращает значение атрибута узла или "".
+func attrVal(n *html.Node, key string) string {
+	for i := range n.Attr {
+		if n.Attr[i].Key == key {
+			return n.Attr[i].Val
+		}
+	}
+	return ""
+}
+
+// srcsetCandidate — один кандидат из img/source srcset: урл + дескриптор
+// ширины/плотности ("640w", "2x" или пусто).
+type srcsetCandidate struct {
+	url        string
+	descriptor string
+}
+
+func parseSrcset(raw string) []srcsetCandidate {
+	var out []srcsetCandidate
+	for _, part := range strings.Split(raw, ",") {
+		fields := strings.Fields(strings.TrimSpace(part))
+		if len(fields) == 0 {
+			continue
+		}
+		c := srcsetCandidate{url: fields[0]}
+		if len(fields) > 1 {
+			c.descriptor = fields[1]
+		}
+		out = append(out, c)
+	}
+	return out
+}
+
+func buildSrcset(candidates []srcsetCandidate) string {
+	parts := make([]string, 0, len(candidates))
+	for _, c := range candidates {
+		if c.descriptor != "" {
+			parts = append(parts, c.url+" "+c.descriptor)
+		} else {
+			parts = append(parts, c.url)
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// collectCSSResources достает все url()/@import цели из куска css-текста
+// (инлайн style="", <style> блок или целый .css файл).
+func (d *downloader) collectCSSResources(css string, base *url.URL) []*url.URL {
+	var out []*url.URL
+	for _, raw := range cssRefs(css) {
+		if u, ok := d.resolve(base, raw); ok {
+			out = append(out, u)
+		}
+	}
+	return out
+}
+
+// rewriteCSSText заменяет каждую url()/@import ссылку на относительный
+// путь до локальной копии.
+func (d *downloader) rewriteCSSText(css string, base *url.URL, currentLocalPath string) string {
+	replace := func(raw string) string {
+		absURL, ok := d.resolve(base, raw)
+		if !ok {
+			return raw
+		}
+		targetLocalPath := d.makeLocalPath(absURL)
+		rel, err := filepath.Rel(filepath.Dir(currentLocalPath), targetLocalPath)
+		if err != nil {
+			return raw
+		}
+		return rel
+	}
+
+	css = cssURLRe.ReplaceAllStringFunc(css, func(m string) string {
+		sub := cssURLRe.FindStringSubmatch(m)
+		return fmt.Sprintf("url(%s)", replace(sub[1]))
+	})
+	css = cssImportRe.ReplaceAllStringFunc(css, func(m string) string {
+		sub := cssImportRe.FindStringSubmatch(m)
+		return fmt.Sprintf("@import %q", replace(sub[1]))
+	})
+	return css
+}
+
+// cssRefs возвращает сырые (еще не резолвленные) ссылки, упомянутые в
+// css-тексте через url(...) или голый @import "...".
+func cssRefs(css string) []string {
+	var out []string
+	for _, m := range cssURLRe.FindAllStringSubmatch(css, -1) {
+		out = append(out, m[1])
+	}
+	for _, m := range cssImportRe.FindAllStringSubmatch(css, -1) {
+		out = append(out, m[1])
+	}
+	return out
+}
+
+// processCSSFile подтягивает все ресурсы, на которые ссылается уже
+// сохраненный .css файл, и переписывает в нем ссылки на локальные копии -
+// без этого скачанный css будет битым оффлайн.
+func (d *downloader) processCSSFile(cssURL *url.URL, localPath string, depth int) {
+	data, err := os.ReadFile(localPath)
+	if err != nil {
+		return
+	}
+
+	for _, res := range d.collectCSSResources(string(data), cssURL) {
+		d.download(res, depth, false)
+	}
+
+	rewritten := d.rewriteCSSText(string(data), cssURL, localPath)
+	if rewritten == string(data) {
+		return
+	}
+	if err := os.WriteFile(localPath, []byte(rewritten), 0644); err != nil {
+		fmt.Printf("Failed to rewrite css %s: %v\n", localPath, err)
+	}
+}
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"bytes"
 	"flag"
 	"fmt"
 	"io"
@@ -13,7 +14,10 @@ import (
 	"sync"
 	"time"
 
+	"github.com/tdewolff/minify/v2"
+	htmlmin "github.com/tdewolff/minify/v2/html"
 	"golang.org/x/net/html"
+	"golang.org/x/time/rate"
 )
 
 type downloader struct {
@@ -22,11 +26,26 @@ type downloader struct {
 	concurrencyLimit int
 	visited          map[string]bool
 	visitedMu        sync.Mutex
-	host             string
+	hosts            HostPolicy
 	rootDir          string
 	wg               sync.WaitGroup
 	sem              chan struct{} // семафор для ограничения одновременных загрузок
 	startURL         string
+	manifest         *manifest
+	continueMode     bool // -continue: докачивать прерванное зеркало, а не качать с нуля
+	processors       []AssetProcessor
+	assetVariants    map[string][]assetVariant // урл -> варианты, сгенерированные конвейером
+	assetMu          sync.Mutex
+	robotsEnabled    bool
+	robots           *robotsPolicy
+	userAgent        string
+	delay            time.Duration
+	jitter           float64
+	limiter          *rate.Limiter
+	filters          filterSet
+	fetcher          Fetcher // используется только -render=headless, см. downloadHeadless
+	renderHeadless   bool
+	minifyHTML       bool // -minify=html, см. saveHTML
 }
 
 func new(limit int, timeout time.Duration) *downloader {
@@ -37,6 +56,7 @@ func new(limit int, timeout time.Duration) *downloader {
 		concurrencyLimit: limit,
 		visited:          make(map[string]bool),
 		sem:              make(chan struct{}, limit),
+		assetVariants:    make(map[string][]assetVariant),
 	}
 }
 
@@ -45,11 +65,49 @@ func main() {
 	var conLimit int
 	var maxDepth int
 	var timeout int
+	var continueMode bool
+	var imgSizesFlag string
+	var minifyFlag string
+	var robotsFlag string
+	var userAgent string
+	var delaySec float64
+	var jitter float64
+	var reqRate float64
+	var acceptFlag string
+	var rejectFlag string
+	var includeDirsFlag string
+	var excludeDirsFlag string
+	var spanHosts bool
+	var domainsFlag string
+	var maxSizeFlag string
+	var minSizeFlag string
+	var quotaFlag string
+	var warcPath string
+	var renderMode string
 
 	flag.StringVar(&startURL, "u", "", "URL to mirror")
 	flag.IntVar(&maxDepth, "d", 1, "Recursion depth")
 	flag.IntVar(&conLimit, "n", 5, "Maximum number of concurrent downloads")
 	flag.IntVar(&timeout, "t", 30, "HTTP client timeout in seconds")
+	flag.BoolVar(&continueMode, "continue", false, "Resume an interrupted mirror using the on-disk manifest")
+	flag.StringVar(&imgSizesFlag, "img-sizes", "", "Comma-separated widths to resize images to, e.g. 320,640,1280")
+	flag.StringVar(&minifyFlag, "minify", "", "Comma-separated asset types to minify: css,js,html")
+	flag.StringVar(&robotsFlag, "robots", "on", "Honor robots.txt: on|off")
+	flag.StringVar(&userAgent, "user-agent", "wget-clone/1.0", "User-Agent header and robots.txt matching")
+	flag.Float64Var(&delaySec, "delay", 0, "Fixed delay between requests, in seconds")
+	flag.Float64Var(&jitter, "jitter", 0, "Random extra delay added on top of -delay, in seconds")
+	flag.Float64Var(&reqRate, "rate", 0, "Maximum requests per second (0 = unlimited)")
+	flag.StringVar(&acceptFlag, "A", "", "Comma-separated glob patterns to accept, matched against the filename")
+	flag.StringVar(&rejectFlag, "R", "", "Comma-separated glob patterns to reject, matched against the filename")
+	flag.StringVar(&includeDirsFlag, "I", "", "Comma-separated path prefixes to include")
+	flag.StringVar(&excludeDirsFlag, "X", "", "Comma-separated path prefixes to exclude")
+	flag.BoolVar(&spanHosts, "H", false, "Span hosts: follow links to hosts other than the start URL's")
+	flag.StringVar(&domainsFlag, "D", "", "Comma-separated domain allowlist, only used with -H")
+	flag.StringVar(&maxSizeFlag, "max-size", "", "Skip bodies larger than this (e.g. 10m, 512k)")
+	flag.StringVar(&minSizeFlag, "min-size", "", "Skip bodies smaller than this (e.g. 1k)")
+	flag.StringVar(&quotaFlag, "Q", "", "Stop once this much total data has been downloaded (e.g. 500m)")
+	flag.StringVar(&warcPath, "warc", "", "Also record every request/response into a WARC file, e.g. out.warc.gz")
+	flag.StringVar(&renderMode, "render", "static", "Page fetch backend: static|headless (headless needs a local Chromium)")
 	flag.Parse()
 
 	if startURL == "" {
@@ -59,6 +117,68 @@ func main() {
 	d := new(conLimit, time.Duration(timeout)*time.Second)
 	d.startURL = startURL
 	d.maxDepth = maxDepth
+	d.continueMode = continueMode
+
+	// собираем конвейер обработки ресурсов из флагов; порядок не важен,
+	// т.к. процессоры не пересекаются по content-type
+	if sizes := parseImgSizes(imgSizesFlag); len(sizes) > 0 {
+		d.processors = append(d.processors, &imageProcessor{sizes: sizes})
+	}
+	if minifyFlag != "" {
+		targets := parseMinifyTargets(minifyFlag)
+		if targets["css"] || targets["js"] {
+			d.processors = append(d.processors, &minifyProcessor{targets: targets})
+		}
+		// html не проходит через AssetProcessor/processAsset: страница не
+		// сохраняется отдельным вариантом рядом с оригиналом как css/js,
+		// итоговый файл и есть localPath, так что минифицируется прямо
+		// перед записью в saveHTML
+		d.minifyHTML = targets["html"]
+	}
+
+	d.userAgent = userAgent
+	d.delay = time.Duration(delaySec * float64(time.Second))
+	d.jitter = jitter
+	if reqRate > 0 {
+		d.limiter = rate.NewLimiter(rate.Limit(reqRate), 1)
+	}
+	d.robotsEnabled = robotsFlag != "off"
+	if d.robotsEnabled {
+		d.robots = newRobotsPolicy(d.client, d.userAgent)
+	}
+
+	d.filters = filterSet{
+		accept:      splitCSV(acceptFlag),
+		reject:      splitCSV(rejectFlag),
+		includeDirs: splitCSV(includeDirsFlag),
+		excludeDirs: splitCSV(excludeDirsFlag),
+		maxSize:     mustParseSize("max-size", maxSizeFlag),
+		minSize:     mustParseSize("min-size", minSizeFlag),
+		quota:       mustParseSize("Q", quotaFlag),
+	}
+
+	if warcPath != "" {
+		ww, err := newWarcWriter(warcPath)
+		if err != nil {
+			log.Fatal(err)
+		}
+		defer ww.Close()
+
+		transport := d.client.Transport
+		if transport == nil {
+			transport = http.DefaultTransport
+		}
+		d.client.Transport = &warcTransport{inner: transport, writer: ww}
+	}
+
+	d.renderHeadless = renderMode == "headless"
+	if d.renderHeadless {
+		hf := newHeadlessFetcher(d.userAgent, time.Duration(timeout)*time.Second)
+		defer hf.Close()
+		d.fetcher = hf
+	} else {
+		d.fetcher = &httpFetcher{client: d.client, userAgent: d.userAgent}
+	}
 
 	start := time.Now()
 
@@ -74,7 +194,7 @@ func main() {
 
 	// запоминаем стартовый хост
 	// например github.com
-	d.host = base.Host
+	d.hosts = HostPolicy{Primary: base.Host, Span: spanHosts, Allow: parseDomainAllowlist(domainsFlag)}
 	d.rootDir = base.Host
 
 	// создаем основную директорию
@@ -82,8 +202,20 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// манифест переживает процесс: это то, что делает повторные прогоны
+	// дешевыми (conditional GET); -continue по нему отличает завершенные
+	// файлы от оборванных .part (visited из манифеста не засеивается)
+	m, err := loadManifest(filepath.Join(d.rootDir, ".wget-state.json"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	d.manifest = m
+
+	// засеиваем обход из sitemap.xml (если есть) до первого запроса к сайту
+	d.seedFromSitemaps(base)
+
 	// запускаем, глубина изначально 0
-	d.download(base, 0)
+	d.download(base, 0, true)
 
 	// ждем завершения всех горутин
 	d.wg.Wait()
@@ -91,7 +223,11 @@ func main() {
 	fmt.Println("Done in:", time.Since(start))
 }
 
-func (d *downloader) download(u *url.URL, depth int) {
+// isPage отличает переход на html-документ (кандидат на headless-рендер)
+// от закачки ресурса (картинка/css/js) - ресурсы всегда идут по статическому
+// HTTP-пути, иначе headless-рендер запишет на диск синтетическую html-обертку
+// вместо настоящих байт ресурса.
+func (d *downloader) download(u *url.URL, depth int, isPage bool) {
 	// каждая загрузка выполняется в отдельной горутине
 	d.wg.Add(1)
 	go func() {
@@ -111,30 +247,103 @@ func (d *downloader) download(u *url.URL, depth int) {
 		d.visited[urlStr] = true
 		d.visitedMu.Unlock()
 
+		// проверяем robots.txt до того как занять слот в семафоре -
+		// запрещенный урл не должен тратить сетевой запрос
+		if !d.allowedByRobots(u) {
+			fmt.Printf("Disallowed by robots.txt: %s\n\n", urlStr)
+			return
+		}
+
+		// хост/-A/-R/-I/-X/-Q тоже проверяются заранее по той же причине
+		if !d.allow(u.Host, u.Path) {
+			fmt.Printf("Filtered out: %s\n\n", urlStr)
+			return
+		}
+
 		// ограничение параллелизма
 		d.sem <- struct{}{}
 		defer func() { <-d.sem }()
 
+		// rate limit / задержка / джиттер / Crawl-delay - вежливый краулинг
+		d.politeWait(u)
+
+		// создаем правильный путь для файла заранее - нужен и для Range, и для сохранения
+		localPath := d.makeLocalPath(u)
+		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
+			fmt.Printf("Failed to create dir for %s: %v\n", localPath, err)
+			return
+		}
+
+		// SPA не отрисовать статическим парсером - рендерим в headless-браузере
+		// и идем дальше по тому же графу ресурсов/страниц, что и обычный путь.
+		// Ресурсы (isPage == false) всегда качаются статически - headless
+		// всегда отдает OuterHTML, что для не-html ресурса значило бы
+		// записать на диск синтетическую обертку вместо настоящих байт.
+		if d.renderHeadless && isPage {
+			d.downloadHeadless(u, urlStr, localPath, depth)
+			return
+		}
+
+		req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+		if err != nil {
+			fmt.Printf("ERROR %s: %v\n\n", urlStr, err)
+			return
+		}
+		req.Header.Set("User-Agent", d.userAgent)
+
+		// если урл уже есть в манифесте - просим сервер подтвердить, что
+		// контент не изменился, вместо того чтобы качать и перезаписывать заново
+		entry, known := d.manifest.get(urlStr)
+		if known {
+			if entry.ETag != "" {
+				req.Header.Set("If-None-Match", entry.ETag)
+			}
+			if entry.LastModified != "" {
+				req.Header.Set("If-Modified-Since", entry.LastModified)
+			}
+		}
+
+		// -continue: если файл уже частично закачан (и манифест еще не
+		// подтвердил его как завершенный), дозакачиваем хвост через Range
+		var resumeOffset int64
+		if d.continueMode && !known {
+			if fi, err := os.Stat(localPath + ".part"); err == nil {
+				resumeOffset = fi.Size()
+				req.Header.Set("Range", fmt.Sprintf("bytes=%d-", resumeOffset))
+			}
+		}
+
 		// делаем get-запрос на урл
-		resp, err := d.client.Get(urlStr)
-		log.Println(urlStr)
-		fmt.Printf("HTTP request sent, awaiting response... %s\n", resp.Status)
+		resp, err := d.client.Do(req)
 		if err != nil {
-			fmt.Printf("ERROR %s\n\n", resp.Status)
+			fmt.Printf("ERROR %s: %v\n\n", urlStr, err)
 			return
 		}
+		log.Println(urlStr)
+		fmt.Printf("HTTP request sent, awaiting response... %s\n", resp.Status)
 		defer resp.Body.Close()
 
-		if resp.StatusCode != http.StatusOK {
+		if resp.StatusCode == http.StatusNotModified {
+			fmt.Printf("'%s' not modified, skipping\n\n", urlStr)
+			// контент не изменился, но граф ссылок все равно нужно пройти -
+			// иначе уже закачанные страницы никогда не открывают своих детей,
+			// и повторный прогон (plain или -continue) не продвигается дальше
+			// первой непустой от кэша страницы
+			if isPage {
+				d.reenqueueFromLocalCopy(u, localPath, depth)
+			}
+			return
+		}
+
+		if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
 			fmt.Printf("ERROR %s\n\n", resp.Status)
 			return
 		}
 
-		// создаем правильный путь для файла
-		localPath := d.makeLocalPath(u)
-		// создаем директорию
-		if err := os.MkdirAll(filepath.Dir(localPath), 0755); err != nil {
-			fmt.Printf("Failed to create dir for %s: %v\n", localPath, err)
+		// --max-size/--min-size: известный Content-Length проверяем сразу,
+		// неизвестный (chunked) обрежется потоково внутри saveNonHTML
+		if !d.filters.acceptSize(resp.ContentLength) {
+			fmt.Printf("Skipping %s: size outside [--min-size, --max-size]\n\n", urlStr)
 			return
 		}
 		fmt.Printf("Saving to: '%s'\n", filepath.Dir(localPath))
@@ -144,14 +353,45 @@ func (d *downloader) download(u *url.URL, depth int) {
 
 		// если не html - сразу сохраняем (io.Copy в файл)
 		if !isHTML {
-			d.saveNonHTML(localPath, resp.Body)
+			appendMode := resp.StatusCode == http.StatusPartialContent
+			n, err := d.saveNonHTML(localPath, resp.Body, appendMode)
+			if err != nil {
+				fmt.Printf("Failed to save %s: %v\n\n", urlStr, err)
+				return
+			}
+			d.filters.addDownloaded(n)
+			d.recordManifestEntry(urlStr, localPath, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+			d.processAsset(urlStr, localPath, contentType)
+			// css-файл может сам ссылаться на другие ресурсы (url()/@import) -
+			// их тоже нужно утянуть и переписать, иначе зеркало не отрендерится
+			if strings.Contains(contentType, "text/css") {
+				d.processCSSFile(u, localPath, depth)
+			}
 			fmt.Printf("'%s' saved\n\n", urlStr)
 			return
 		}
 
 		// если html - нужно парсить
 		// парсим html
-		doc, err := html.Parse(resp.Body)
+		// resp.ContentLength уже проверен выше через acceptSize, но для
+		// chunked-ответов (ContentLength < 0) это пропускает проверку -
+		// досюда --max-size досчитывается тем же приемом, что и в
+		// saveNonHTML: режем поток на maxSize+1 байте и считаем превышением
+		htmlBody := io.Reader(resp.Body)
+		if d.filters.maxSize > 0 && resp.ContentLength < 0 {
+			data, err := io.ReadAll(io.LimitReader(resp.Body, d.filters.maxSize+1))
+			if err != nil {
+				fmt.Printf("Failed to read %s: %v\n\n", urlStr, err)
+				return
+			}
+			if int64(len(data)) > d.filters.maxSize {
+				fmt.Printf("Skipping %s: exceeds --max-size\n\n", urlStr)
+				return
+			}
+			htmlBody = bytes.NewReader(data)
+		}
+
+		doc, err := html.Parse(htmlBody)
 		if err != nil {
 			fmt.Printf("Failed to parse HTML %s: %v\n\n", urlStr, err)
 			return
@@ -164,7 +404,7 @@ func (d *downloader) download(u *url.URL, depth int) {
 		// рекурсивно обрабатываем урлы ресурсов, не увеличивая глубину
 		// ресурс не считается переходом на новую страницу
 		for _, res := range resources {
-			d.download(res, depth)
+			d.download(res, depth, false)
 		}
 
 		// перезаписываем ссылки в распаршенном html на локальные
@@ -173,35 +413,133 @@ func (d *downloader) download(u *url.URL, depth int) {
 		// сохраняем html (html.Render в файл)
 		if err := d.saveHTML(localPath, doc); err != nil {
 			fmt.Printf("Failed to save HTML %s: %v\n\n", localPath, err)
+		} else {
+			d.recordManifestEntry(urlStr, localPath, resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"))
+			if fi, err := os.Stat(localPath); err == nil {
+				d.filters.addDownloaded(fi.Size())
+			}
 		}
 		fmt.Printf("'%s' saved\n\n", urlStr)
 
 		// рекурсивно обрабатываем урлы страниц, увеличивая глубину
 		for _, page := range pages {
-			d.download(page, depth+1)
+			d.download(page, depth+1, true)
 		}
 	}()
 }
 
+// reenqueueFromLocalCopy открывает уже сохраненную с прошлого прогона html-
+// страницу (304 Not Modified) и проходит по ее ссылкам, не трогая сам файл -
+// сеть и диск экономятся, но дети страницы все равно (пере)обходятся.
+func (d *downloader) reenqueueFromLocalCopy(u *url.URL, localPath string, depth int) {
+	f, err := os.Open(localPath)
+	if err != nil {
+		fmt.Printf("Failed to open cached copy %s: %v\n", localPath, err)
+		return
+	}
+	defer f.Close()
+
+	doc, err := html.Parse(f)
+	if err != nil {
+		fmt.Printf("Failed to parse cached copy %s: %v\n", localPath, err)
+		return
+	}
+
+	resources, pages := d.collectLinks(doc, u)
+	for _, res := range resources {
+		d.download(res, depth, false)
+	}
+	for _, page := range pages {
+		d.download(page, depth+1, true)
+	}
+}
+
 // например github.com/andreyxaxa/order_svc -> github.com + andreyxaxa/order_svc + index.html
 // host + path + index.html (если в конце директория | или файл оканчивается на "/")
+// урлы с других хостов (-H) кладутся в поддиректорию по своему хосту,
+// чтобы не коллизировать с одноименными путями на основном хосте
 func (d *downloader) makeLocalPath(u *url.URL) string {
-	path := filepath.Join(d.rootDir, filepath.Clean(u.Path))
+	root := d.rootDir
+	if u.Host != d.hosts.Primary {
+		root = filepath.Join(d.rootDir, u.Host)
+	}
+
+	path := filepath.Join(root, filepath.Clean(u.Path))
 	if u.Path == "" || strings.HasSuffix(u.Path, "/") || filepath.Ext(path) == "" {
 		path = filepath.Join(path, "index.html")
 	}
 	return path
 }
 
-// os.Create() + io.Copy()
-func (d *downloader) saveNonHTML(localPath string, body io.Reader) {
-	f, err := os.Create(localPath)
+// saveNonHTML пишет тело ответа в localPath. Пока загрузка не завершена,
+// данные копятся в "<localPath>.part" - так прерванная на середине закачка
+// не притворяется готовым файлом и ее можно дозакачать через Range.
+// Если задан --max-size и сервер не прислал Content-Length (chunked),
+// копирование обрывается на maxSize+1 байте и считается превышением.
+func (d *downloader) saveNonHTML(localPath string, body io.Reader, appendMode bool) (int64, error) {
+	partPath := localPath + ".part"
+
+	flags := os.O_CREATE | os.O_WRONLY
+	if appendMode {
+		flags |= os.O_APPEND
+	} else {
+		flags |= os.O_TRUNC
+	}
+
+	f, err := os.OpenFile(partPath, flags, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	src := body
+	if d.filters.maxSize > 0 {
+		src = io.LimitReader(body, d.filters.maxSize+1)
+	}
+
+	n, err := io.Copy(f, src)
+	f.Close()
+	if err != nil {
+		return n, err
+	}
+
+	if d.filters.maxSize > 0 && n > d.filters.maxSize {
+		os.Remove(partPath)
+		return n, fmt.Errorf("exceeds --max-size (%d > %d bytes)", n, d.filters.maxSize)
+	}
+
+	if err := os.Rename(partPath, localPath); err != nil {
+		return n, err
+	}
+	return n, nil
+}
+
+// recordManifestEntry заносит успешно сохраненный урл в манифест, чтобы
+// следующий прогон мог сделать conditional GET вместо полной перезакачки.
+// etag/lastModified приходят как явные строки, а не *http.Response, чтобы
+// вызывающим кодом мог быть и статический путь, и headless (у которого
+// нет настоящего http.Response на итоговый документ).
+func (d *downloader) recordManifestEntry(urlStr, localPath, etag, lastModified string) {
+	sum, err := hashFile(localPath)
 	if err != nil {
-		log.Printf("Failed to create file %s: %v", localPath, err)
+		log.Printf("Failed to hash %s: %v", localPath, err)
 		return
 	}
-	defer f.Close()
-	io.Copy(f, body)
+
+	fi, err := os.Stat(localPath)
+	if err != nil {
+		log.Printf("Failed to stat %s: %v", localPath, err)
+		return
+	}
+
+	d.manifest.set(urlStr, manifestEntry{
+		ETag:         etag,
+		LastModified: lastModified,
+		Size:         fi.Size(),
+		Sha256:       sum,
+	})
+	if err := d.manifest.save(); err != nil {
+		log.Printf("Failed to save manifest: %v", err)
+	}
 }
 
 // os.Create() + html.Render()
@@ -211,7 +549,24 @@ func (d *downloader) saveHTML(localPath string, doc *html.Node) error {
 		return err
 	}
 	defer f.Close()
-	return html.Render(f, doc)
+
+	if !d.minifyHTML {
+		return html.Render(f, doc)
+	}
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return err
+	}
+
+	m := minify.New()
+	m.AddFunc("text/html", htmlmin.Minify)
+	minified, err := m.Bytes("text/html", buf.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(minified)
+	return err
 }
 
 // обход DOM-дерева
@@ -222,101 +577,6 @@ func traverse(n *html.Node, fn func(*html.Node)) {
 	}
 }
 
-func (d *downloader) collectLinks(doc *html.Node, base *url.URL) (resources, pages []*url.URL) {
-	traverse(doc, func(n *html.Node) {
-		// тег?
-		if n.Type != html.ElementNode {
-			return
-		}
-
-		var attrKey string
-		isPageLink := false
-
-		// смотрим имя тега
-		// просто будем разделять ресурсы и страницы
-		switch n.Data {
-		case "a":
-			attrKey = "href"
-			isPageLink = true
-		case "link":
-			attrKey = "href"
-		case "script", "img":
-			attrKey = "src"
-		default:
-			return
-		}
-
-		for i := range n.Attr {
-			if n.Attr[i].Key != attrKey {
-				continue
-			}
-
-			// берем абсолютный урл
-			absURL, err := base.Parse(n.Attr[i].Val)
-			if err != nil {
-				continue
-			}
-			if absURL.Scheme == "" {
-				absURL.Scheme = base.Scheme
-			}
-			// совпадает ли хост
-			if absURL.Host != d.host || (absURL.Scheme != "http" && absURL.Scheme != "https") {
-				continue
-			}
-			absURL.Fragment = ""
-
-			// делим на страницы и ресурсы
-			if isPageLink {
-				pages = append(pages, absURL)
-			} else {
-				resources = append(resources, absURL)
-			}
-			break
-		}
-	})
-
-	return resources, pages
-}
-
-func (d *downloader) rewriteLinks(doc *html.Node, base *url.URL, currentLocalPath string) {
-	traverse(doc, func(n *html.Node) {
-		if n.Type != html.ElementNode {
-			return
-		}
-
-		var attrKey string
-		switch n.Data {
-		case "a", "link":
-			attrKey = "href"
-		case "script", "img":
-			attrKey = "src"
-		default:
-			return
-		}
-
-		for i := range n.Attr {
-			if n.Attr[i].Key != attrKey {
-				continue
-			}
-
-			// берем абсолютную ссылку
-			absURL, err := base.Parse(n.Attr[i].Val)
-			// совпадает ли хост
-			if err != nil || absURL.Host != d.host {
-				continue
-			}
-
-			// создаем локальное представление для абсолютного урла
-			targetLocalPath := d.makeLocalPath(absURL)
-			// создаем относительный путь от текущего(от которого запускалась 'rewriteLinks()' до локального представления абс. урла)
-			relPath, err := filepath.Rel(filepath.Dir(currentLocalPath), targetLocalPath)
-			if err != nil {
-				continue
-			}
-
-			// перезаписываем глобальные пути на свои
-			n.Attr[i].Val = relPath
-			break
-		}
-	})
-}
+// collectLinks и rewriteLinks вынесены в links.go - там же живет разбор
+// srcset/css url()/@import, т.к. вместе они образуют единый граф ресурсов
+// страницы.
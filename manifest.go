@@ -0,0 +1,101 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+)
+
+// manifestEntry описывает состояние одного ранее загруженного урла,
+// достаточное чтобы на следующем прогоне решить: качать заново, сделать
+// conditional GET или дозакачать оборванный файл.
+type manifestEntry struct {
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	Size         int64  `json:"size"`
+	Sha256       string `json:"sha256"`
+}
+
+// manifest — персистентный стейт зеркала, живет рядом со скачанными
+// файлами как "<rootDir>/.wget-state.json".
+type manifest struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]manifestEntry
+}
+
+// loadManifest читает манифест с диска. Если файла еще нет (первый
+// прогон), возвращает пустой манифест без ошибки.
+func loadManifest(path string) (*manifest, error) {
+	m := &manifest{path: path, entries: make(map[string]manifestEntry)}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(data, &m.entries); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (m *manifest) get(urlStr string) (manifestEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	e, ok := m.entries[urlStr]
+	return e, ok
+}
+
+func (m *manifest) set(urlStr string, e manifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[urlStr] = e
+}
+
+// urls возвращает все урлы, для которых уже есть запись в манифесте.
+// Сейчас не вызывается: -continue не засеивает visited из манифеста.
+func (m *manifest) urls() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	out := make([]string, 0, len(m.entries))
+	for u := range m.entries {
+		out = append(out, u)
+	}
+	return out
+}
+
+// save сериализует манифест на диск. Вызывается после каждой успешной
+// загрузки, так что прерванный прогон теряет не больше одного файла.
+func (m *manifest) save() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(m.path, data, 0644)
+}
+
+// hashFile считает sha256 уже сохраненного файла, чтобы занести его в
+// манифест вместе с ETag/Last-Modified.
+func hashFile(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
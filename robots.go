@@ -0,0 +1,306 @@
+package main
+
+import (
+	"context"
+	"encoding/xml"
+	"io"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// hostRules — правила robots.txt, которые относятся к нашему user-agent
+// на конкретном хосте.
+type hostRules struct {
+	disallow   []string
+	allow      []string
+	crawlDelay time.Duration
+	sitemaps   []string
+}
+
+// allowed реализует стандартный алгоритм robots.txt: среди всех
+// disallow/allow правил побеждает самое длинное совпадающее по префиксу;
+// при отсутствии совпадений путь разрешен.
+func (r *hostRules) allowed(path string) bool {
+	if r == nil {
+		return true
+	}
+
+	bestDisallow, bestAllow := -1, -1
+	for _, p := range r.disallow {
+		if p != "" && strings.HasPrefix(path, p) && len(p) > bestDisallow {
+			bestDisallow = len(p)
+		}
+	}
+	for _, p := range r.allow {
+		if p != "" && strings.HasPrefix(path, p) && len(p) > bestAllow {
+			bestAllow = len(p)
+		}
+	}
+
+	if bestDisallow < 0 {
+		return true
+	}
+	return bestAllow >= bestDisallow
+}
+
+// robotsPolicy кэширует правила robots.txt по хосту, так что они
+// запрашиваются один раз за весь прогон.
+type robotsPolicy struct {
+	mu        sync.Mutex
+	client    *http.Client
+	userAgent string
+	rules     map[string]*hostRules
+}
+
+func newRobotsPolicy(client *http.Client, userAgent string) *robotsPolicy {
+	return &robotsPolicy{
+		client:    client,
+		userAgent: userAgent,
+		rules:     make(map[string]*hostRules),
+	}
+}
+
+// rulesFor возвращает правила для хоста, при необходимости загружая и
+// парся "<scheme>://<host>/robots.txt".
+func (p *robotsPolicy) rulesFor(scheme, host string) *hostRules {
+	p.mu.Lock()
+	if r, ok := p.rules[host]; ok {
+		p.mu.Unlock()
+		return r
+	}
+	p.mu.Unlock()
+
+	r := p.fetch(scheme, host)
+
+	p.mu.Lock()
+	p.rules[host] = r
+	p.mu.Unlock()
+	return r
+}
+
+func (p *robotsPolicy) fetch(scheme, host string) *hostRules {
+	req, err := http.NewRequest(http.MethodGet, scheme+"://"+host+"/robots.txt", nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", p.userAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil
+	}
+	return parseRobots(string(body), p.userAgent)
+}
+
+// parseRobots разбирает текст robots.txt. Группы (разделенные строками
+// User-agent) собираются независимо друг от друга, и только после полного
+// разбора выбирается одна - наиболее специфичная для нашего user-agent,
+// либо "*" если специфичной группы нет. Так конкретная группа всегда
+// побеждает общую "*", даже если "*" встретилась раньше в файле.
+func parseRobots(body, userAgent string) *hostRules {
+	var sitemaps []string
+	specific := &hostRules{}
+	generic := &hostRules{}
+	haveSpecific := false
+	haveGeneric := false
+
+	var current *hostRules
+	for _, line := range strings.Split(body, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		key, val, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		val = strings.TrimSpace(val)
+
+		switch key {
+		case "user-agent":
+			switch {
+			case strings.EqualFold(val, userAgent):
+				current = specific
+				haveSpecific = true
+			case val == "*":
+				current = generic
+				haveGeneric = true
+			default:
+				current = nil
+			}
+		case "disallow":
+			if current != nil {
+				current.disallow = append(current.disallow, val)
+			}
+		case "allow":
+			if current != nil {
+				current.allow = append(current.allow, val)
+			}
+		case "crawl-delay":
+			if current != nil {
+				if secs, err := strconv.ParseFloat(val, 64); err == nil {
+					current.crawlDelay = time.Duration(secs * float64(time.Second))
+				}
+			}
+		case "sitemap":
+			// sitemap применим вне зависимости от секции user-agent
+			sitemaps = append(sitemaps, val)
+		}
+	}
+
+	var r *hostRules
+	if haveSpecific {
+		r = specific
+	} else if haveGeneric {
+		r = generic
+	} else {
+		r = &hostRules{}
+	}
+	r.sitemaps = sitemaps
+	return r
+}
+
+// allowedByRobots проверяет, можно ли качать u согласно robots.txt его
+// хоста. Вызывается перед тем как занять место в семафоре - отклоненный
+// урл не должен тратить сетевой запрос.
+func (d *downloader) allowedByRobots(u *url.URL) bool {
+	if !d.robotsEnabled {
+		return true
+	}
+	rules := d.robots.rulesFor(u.Scheme, u.Host)
+	return rules.allowed(u.Path)
+}
+
+// crawlDelayFor возвращает Crawl-delay хоста, если robots.txt его задает.
+func (d *downloader) crawlDelayFor(u *url.URL) time.Duration {
+	if !d.robotsEnabled {
+		return 0
+	}
+	rules := d.robots.rulesFor(u.Scheme, u.Host)
+	if rules == nil {
+		return 0
+	}
+	return rules.crawlDelay
+}
+
+// politeWait применяет rate limit, фиксированную задержку, джиттер и
+// Crawl-delay хоста перед выполнением запроса - вежливый краулинг не
+// должен долбить сервер быстрее, чем он попросил.
+func (d *downloader) politeWait(u *url.URL) {
+	if d.limiter != nil {
+		d.limiter.Wait(context.Background())
+	}
+
+	wait := d.delay
+	if cd := d.crawlDelayFor(u); cd > wait {
+		wait = cd
+	}
+	if d.jitter > 0 {
+		wait += time.Duration(rand.Float64() * d.jitter * float64(time.Second))
+	}
+	if wait > 0 {
+		time.Sleep(wait)
+	}
+}
+
+// sitemapURLSet и sitemapIndex — минимальный набор тегов, нужный чтобы
+// достать <loc> из sitemap.xml и sitemap-индексов.
+type sitemapURLSet struct {
+	XMLName xml.Name `xml:"urlset"`
+	URLs    []struct {
+		Loc string `xml:"loc"`
+	} `xml:"url"`
+}
+
+type sitemapIndex struct {
+	XMLName  xml.Name `xml:"sitemapindex"`
+	Sitemaps []struct {
+		Loc string `xml:"loc"`
+	} `xml:"sitemap"`
+}
+
+// seedFromSitemaps скачивает каждый sitemap, объявленный в robots.txt (или
+// "/sitemap.xml" по умолчанию), и отправляет найденные урлы в обход наравне
+// с обычными ссылками страницы.
+func (d *downloader) seedFromSitemaps(base *url.URL) {
+	if !d.robotsEnabled {
+		return
+	}
+
+	rules := d.robots.rulesFor(base.Scheme, base.Host)
+	sitemaps := []string{}
+	if rules != nil {
+		sitemaps = rules.sitemaps
+	}
+	if len(sitemaps) == 0 {
+		sitemaps = []string{base.Scheme + "://" + base.Host + "/sitemap.xml"}
+	}
+
+	for _, sm := range sitemaps {
+		d.fetchSitemap(sm, 0)
+	}
+}
+
+// depth ограничивает рекурсию по sitemap-индексам (индекс ссылается на
+// другие sitemap'ы, которые в редких случаях ссылаются друг на друга по кругу).
+func (d *downloader) fetchSitemap(rawURL string, depth int) {
+	if depth > 3 {
+		return
+	}
+
+	req, err := http.NewRequest(http.MethodGet, rawURL, nil)
+	if err != nil {
+		return
+	}
+	req.Header.Set("User-Agent", d.userAgent)
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return
+	}
+
+	var index sitemapIndex
+	if xml.Unmarshal(body, &index) == nil && len(index.Sitemaps) > 0 {
+		for _, sm := range index.Sitemaps {
+			d.fetchSitemap(sm.Loc, depth+1)
+		}
+		return
+	}
+
+	var set sitemapURLSet
+	if xml.Unmarshal(body, &set) != nil {
+		return
+	}
+	for _, entry := range set.URLs {
+		u, err := url.Parse(entry.Loc)
+		if err != nil || !d.hosts.Matches(u.Host) {
+			continue
+		}
+		d.download(u, 0, true)
+	}
+}
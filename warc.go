@@ -0,0 +1,174 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// warcWriter сериализует WARC-записи в файл, по одной за раз под
+// мьютексом - конкурентные загрузки не должны перемежать байты записей.
+type warcWriter struct {
+	mu     sync.Mutex
+	f      *os.File
+	gzipOn bool
+}
+
+func newWarcWriter(path string) (*warcWriter, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	w := &warcWriter{f: f, gzipOn: strings.HasSuffix(path, ".gz")}
+
+	if err := w.writeWarcinfo(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *warcWriter) Close() error {
+	return w.f.Close()
+}
+
+// writeWarcinfo — первая запись файла, описывает сам обход.
+func (w *warcWriter) writeWarcinfo() error {
+	payload := []byte("software: wget-clone\r\nformat: WARC File Format 1.0\r\n")
+	headers := map[string]string{
+		"WARC-Type":    "warcinfo",
+		"Content-Type": "application/warc-fields",
+	}
+	return w.write(headers, payload)
+}
+
+// writeExchange пишет пару "request"+"response" записей для одного
+// HTTP-обмена, связывая их через WARC-Concurrent-To. body - это payload
+// ответа отдельно от respDump, т.к. WARC-Payload-Digest обязан хэшировать
+// только тело, а не заголовки+тело.
+func (w *warcWriter) writeExchange(targetURI string, reqDump, respDump, body []byte) error {
+	reqID := newWarcRecordID()
+
+	reqHeaders := map[string]string{
+		"WARC-Type":       "request",
+		"WARC-Target-URI": targetURI,
+		"WARC-Record-ID":  reqID,
+		"Content-Type":    "application/http; msgtype=request",
+	}
+	if err := w.writeWithID(reqHeaders, reqDump); err != nil {
+		return err
+	}
+
+	sum := sha1.Sum(body)
+	respHeaders := map[string]string{
+		"WARC-Type":           "response",
+		"WARC-Target-URI":     targetURI,
+		"WARC-Record-ID":      newWarcRecordID(),
+		"WARC-Concurrent-To":  reqID,
+		"Content-Type":        "application/http; msgtype=response",
+		"WARC-Payload-Digest": "sha1:" + hex.EncodeToString(sum[:]),
+	}
+	return w.writeWithID(respHeaders, respDump)
+}
+
+// write выписывает WARC-Record-ID сама (используется для warcinfo,
+// которому конкретный id не важен для связывания с другими записями).
+func (w *warcWriter) write(headers map[string]string, payload []byte) error {
+	headers["WARC-Record-ID"] = newWarcRecordID()
+	return w.writeWithID(headers, payload)
+}
+
+func (w *warcWriter) writeWithID(headers map[string]string, payload []byte) error {
+	var buf bytes.Buffer
+	buf.WriteString("WARC/1.0\r\n")
+	buf.WriteString("WARC-Date: " + time.Now().UTC().Format(time.RFC3339) + "\r\n")
+	buf.WriteString(fmt.Sprintf("Content-Length: %d\r\n", len(payload)))
+	for k, v := range headers {
+		if k == "WARC-Record-ID" {
+			continue
+		}
+		buf.WriteString(k + ": " + v + "\r\n")
+	}
+	buf.WriteString("WARC-Record-ID: " + headers["WARC-Record-ID"] + "\r\n")
+	buf.WriteString("\r\n")
+	buf.Write(payload)
+	buf.WriteString("\r\n\r\n")
+
+	out := buf.Bytes()
+	if w.gzipOn {
+		var gzBuf bytes.Buffer
+		gw := gzip.NewWriter(&gzBuf)
+		if _, err := gw.Write(out); err != nil {
+			return err
+		}
+		if err := gw.Close(); err != nil {
+			return err
+		}
+		out = gzBuf.Bytes()
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	_, err := w.f.Write(out)
+	return err
+}
+
+// newWarcRecordID генерирует "urn:uuid:<uuid4>", как того требует спека
+// WARC для WARC-Record-ID.
+func newWarcRecordID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("urn:uuid:%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// warcTransport оборачивает исходный Transport: перед запросом дампит
+// его, после ответа дампит и заголовки, и тело, и пишет пару записей в
+// WARC-файл. Тело ответа прочитывается целиком и подменяется, так что
+// вызывающий код (saveNonHTML/html.Parse) по-прежнему видит его целиком.
+type warcTransport struct {
+	inner  http.RoundTripper
+	writer *warcWriter
+}
+
+func (t *warcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	reqDump, err := httputil.DumpRequest(req, true)
+	if err != nil {
+		reqDump = []byte{}
+	}
+
+	resp, err := t.inner.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return resp, err
+	}
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	respDump, err := httputil.DumpResponse(resp, false)
+	if err == nil {
+		respDump = append(respDump, body...)
+	}
+
+	if err := t.writer.writeExchange(req.URL.String(), reqDump, respDump, body); err != nil {
+		fmt.Printf("Failed to write WARC record for %s: %v\n", req.URL, err)
+	}
+
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+	return resp, nil
+}